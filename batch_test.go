@@ -0,0 +1,104 @@
+package eagle
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+func TestBatchReplayRoundTrip(t *testing.T) {
+	b := NewBatch()
+	b.Put([]byte("k1"), []byte("v1"))
+	b.Delete([]byte("k2"))
+	b.seq = 10
+
+	decoded, err := decodeBatch(b.encode())
+	if err != nil {
+		t.Fatalf("decodeBatch: %v", err)
+	}
+
+	var got []string
+	err = decoded.Replay(batchHandlerFuncs{
+		put: func(seq uint64, key, value []byte) {
+			got = append(got, fmt.Sprintf("put(%d,%s,%s)", seq, key, value))
+		},
+		del: func(seq uint64, key []byte) {
+			got = append(got, fmt.Sprintf("del(%d,%s)", seq, key))
+		},
+	})
+	if err != nil {
+		t.Fatalf("Replay: %v", err)
+	}
+
+	want := []string{"put(10,k1,v1)", "del(11,k2)"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+}
+
+// TestApplyBatchIsAtomicAcrossPartitions writes and deletes the same set
+// of keys, spread across multiple partitions, over and over while a
+// concurrent reader scans the table. The reader must only ever see all
+// of the keys or none of them - never a partial batch.
+func TestApplyBatchIsAtomicAcrossPartitions(t *testing.T) {
+	mt := newMemTable(WithNumPartitions(4))
+	valueOf := func(v []byte) *ValuePointer { return &ValuePointer{} }
+
+	keys := [][]byte{[]byte("a"), []byte("bb"), []byte("ccc"), []byte("dddd"), []byte("eeeee")}
+
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var partial bool
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+
+			n := 0
+			it := mt.NewIterator(nil, nil)
+			for it.Next() {
+				n++
+			}
+			if n != 0 && n != len(keys) {
+				mu.Lock()
+				partial = true
+				mu.Unlock()
+			}
+		}
+	}()
+
+	for i := 0; i < 500; i++ {
+		put := NewBatch()
+		for _, k := range keys {
+			put.Put(k, []byte("v"))
+		}
+		put.seq = uint64(2 * i * len(keys))
+		if err := mt.ApplyBatch(put, valueOf); err != nil {
+			t.Fatalf("ApplyBatch(put): %v", err)
+		}
+
+		del := NewBatch()
+		for _, k := range keys {
+			del.Delete(k)
+		}
+		del.seq = uint64((2*i+1)*len(keys))
+		if err := mt.ApplyBatch(del, valueOf); err != nil {
+			t.Fatalf("ApplyBatch(del): %v", err)
+		}
+	}
+
+	close(stop)
+	wg.Wait()
+
+	if partial {
+		t.Fatal("observed a partially applied batch: some but not all keys were visible")
+	}
+}
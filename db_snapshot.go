@@ -0,0 +1,129 @@
+package eagle
+
+import (
+	"sort"
+	"sync"
+)
+
+// snapshotList tracks the seqNumbers of all currently live snapshots, so
+// writers know how far back a key's version chain must be retained.
+type snapshotList struct {
+	mu   sync.Mutex
+	seqs []uint64
+	refs map[uint64]int
+}
+
+func newSnapshotList() *snapshotList {
+	return &snapshotList{refs: map[uint64]int{}}
+}
+
+// acquire registers seq as a live snapshot, or adds a reference to it if
+// one is already live.
+func (l *snapshotList) acquire(seq uint64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.acquireLocked(seq)
+}
+
+func (l *snapshotList) acquireLocked(seq uint64) {
+	if l.refs[seq] == 0 {
+		i := sort.Search(len(l.seqs), func(i int) bool { return l.seqs[i] >= seq })
+		l.seqs = append(l.seqs, 0)
+		copy(l.seqs[i+1:], l.seqs[i:])
+		l.seqs[i] = seq
+	}
+	l.refs[seq]++
+}
+
+// acquireCurrent reads currentSeq() and registers the result as a live
+// snapshot as a single step under l.mu, so a concurrent seqNumber bump
+// can't land in between and prune a version this snapshot needs before
+// it's registered. DB.Write takes l.mu (via WithLock) around its call to
+// DB.allocSeqNumbers for the same reason.
+func (l *snapshotList) acquireCurrent(currentSeq func() uint64) uint64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	seq := currentSeq()
+	l.acquireLocked(seq)
+	return seq
+}
+
+// WithLock runs fn while holding l's mutex. DB.Write wraps its seqNumber
+// bump in this so it can never interleave with acquireCurrent.
+func (l *snapshotList) WithLock(fn func()) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	fn()
+}
+
+// release drops a reference to seq and reports whether that was the last
+// one, i.e. whether a compaction pass can now reclaim versions retained
+// only on seq's behalf.
+func (l *snapshotList) release(seq uint64) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.refs[seq]--
+	if l.refs[seq] > 0 {
+		return false
+	}
+
+	delete(l.refs, seq)
+	i := sort.Search(len(l.seqs), func(i int) bool { return l.seqs[i] >= seq })
+	l.seqs = append(l.seqs[:i], l.seqs[i+1:]...)
+	return true
+}
+
+// Oldest returns the smallest live snapshot seqNumber, if any.
+func (l *snapshotList) Oldest() (uint64, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if len(l.seqs) == 0 {
+		return 0, false
+	}
+	return l.seqs[0], true
+}
+
+// DBSnapshot is an opaque, reference-counted handle on the DB's state as
+// of the seqNumber current when it was taken. Reads through it never
+// observe writes committed afterwards.
+type DBSnapshot struct {
+	db   *DB
+	seq  uint64
+	view Snapshot
+}
+
+// Snapshot pins the DB's current seqNumber and returns a handle whose
+// reads stay consistent as of that point regardless of later writes.
+// Callers must call Release when done with it.
+func (db *DB) Snapshot() *DBSnapshot {
+	seq := db.snapshots.acquireCurrent(db.currentSeqNumber)
+	return &DBSnapshot{db: db, seq: seq, view: db.memTable.NewSnapshot(seq)}
+}
+
+// Get reads key as it was when the snapshot was taken.
+func (s *DBSnapshot) Get(key []byte) (*ValuePointer, bool) {
+	return s.view.Get(key)
+}
+
+// NewIterator iterates the snapshot's view of [lowerBound, upperBound).
+func (s *DBSnapshot) NewIterator(lowerBound, upperBound []byte) Iterator {
+	return s.view.NewIterator(lowerBound, upperBound)
+}
+
+// Release drops the snapshot's reference on its seqNumber. Once nothing
+// references that seqNumber any more, a background compaction pass walks
+// the memtable and prunes versions that were being kept only for it.
+func (s *DBSnapshot) Release() {
+	if s.db.snapshots.release(s.seq) {
+		go s.db.compactVersions()
+	}
+}
+
+// compactVersions asks the memtable to drop version history no live
+// snapshot can reach any more.
+func (db *DB) compactVersions() {
+	db.memTable.CompactVersions()
+}
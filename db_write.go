@@ -0,0 +1,20 @@
+package eagle
+
+// Write atomically applies batch to the DB: it assigns the batch a base
+// seqNumber, appends it to the WAL as a single record, and replays it
+// into the memtable with every entry using base+i as its seqNumber.
+//
+// The seqNumber bump runs under db.snapshots' lock so a concurrent
+// DB.Snapshot can't register itself between reading the old seqNumber
+// and this write pruning the version it needed.
+func (db *DB) Write(batch *Batch) error {
+	db.snapshots.WithLock(func() {
+		batch.seq = db.allocSeqNumbers(uint64(batch.Len()))
+	})
+
+	if err := db.wal.Write(batch.encode()); err != nil {
+		return err
+	}
+
+	return db.memTable.ApplyBatch(batch, db.newValuePointer)
+}
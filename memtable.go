@@ -2,17 +2,98 @@ package eagle
 
 import (
 	"bytes"
+	"math"
+	"sort"
 	"sync"
 
+	"github.com/cespare/xxhash/v2"
 	"github.com/ostafen/eagle/util"
-	"github.com/spaolacci/murmur3"
 )
 
-type node struct {
-	seqNumber uint64
-	key       []byte
-	next      *node
+// Iterator walks a MemTable's entries in ascending key order.
+// It is forward-only and must be advanced with Next before the
+// first call to Key/Value/SeqNumber.
+type Iterator interface {
+	Next() bool
+	Key() []byte
+	Value() *ValuePointer
+	SeqNumber() uint64
+	Err() error
+	Close() error
+}
+
+// Snapshot is a read-only, point-in-time view over a MemTable.
+type Snapshot interface {
+	Get(key []byte) (*ValuePointer, bool)
+	NewIterator(lowerBound, upperBound []byte) Iterator
+}
+
+// MemTable is the in-memory write buffer backing a DB. It is implemented
+// by both the sharded hash table (memTable) and the ordered skip list
+// (skipMemTable), so the DB can pick either one via an option.
+type MemTable interface {
+	Get(key []byte) (*ValuePointer, uint64)
+	Put(key []byte, seqNumber uint64, ptr *ValuePointer) (*ValuePointer, bool)
+	Remove(key []byte, seqNumber uint64) *ValuePointer
+	ContainsKey(key []byte) bool
+	Size() int
+	NewIterator(lowerBound, upperBound []byte) Iterator
+	NewSnapshot(seq uint64) Snapshot
+
+	// ApplyBatch applies every operation recorded in batch, turning each
+	// put's raw value into a *ValuePointer via valueOf. Implementations
+	// must apply the whole batch atomically with respect to readers.
+	ApplyBatch(batch *Batch, valueOf func(value []byte) *ValuePointer) error
+
+	// SetRetention wires in the source of truth for how much version
+	// history writes must keep around for live snapshots.
+	SetRetention(r snapshotRetention)
+	// CompactVersions drops version history no live snapshot can reach
+	// any more.
+	CompactVersions()
+}
+
+// version is one entry in a key's version chain. Chains are kept sorted
+// descending by seqNumber, so the head is always the newest write and a
+// reader pinned to an older seqNumber can walk past it to find the
+// version that was current at that point.
+type version struct {
 	ptr       *ValuePointer
+	seqNumber uint64
+	next      *version
+}
+
+type node struct {
+	key      []byte
+	hash     uint64 // stashed so resizeStep never has to re-hash the key
+	next     *node
+	versions *version
+}
+
+// headSeq returns the seqNumber of n's newest version, or 0 if n has none
+// yet (a freshly-created node awaiting its first put).
+func (n *node) headSeq() uint64 {
+	if n.versions == nil {
+		return 0
+	}
+	return n.versions.seqNumber
+}
+
+// headPtr returns n's newest version's pointer, or nil if n has none yet.
+func (n *node) headPtr() *ValuePointer {
+	if n.versions == nil {
+		return nil
+	}
+	return n.versions.ptr
+}
+
+// snapshotRetention reports the oldest seqNumber among currently live
+// snapshots, so a writer superseding a version knows how far back it
+// must keep the chain. A nil retention (the default, before a DB wires
+// one in) behaves as if no snapshot were live, collapsing each key down
+// to its newest version only.
+type snapshotRetention interface {
+	Oldest() (seq uint64, ok bool)
 }
 
 type tablePartition struct {
@@ -22,34 +103,79 @@ type tablePartition struct {
 	buckets         [][]*node
 	nElements       util.AtomicInt32
 	nNodes          util.AtomicInt32
+	retention       snapshotRetention
+
+	nodePool       sync.Pool
+	bucketFreeList map[int][]*node
 }
 
 type memTable struct {
 	locks      []sync.RWMutex
 	partitions []*tablePartition
+
+	numPartitions int
+	hashFunc      func(key []byte) uint64
 }
 
 const (
-	initialBucketSize = 4
-	numPartitions     = 16
+	initialBucketSize    = 4
+	defaultNumPartitions = 16
 )
 
-func newMemTable() *memTable {
-	partitions := make([]*tablePartition, numPartitions)
-	for i := 0; i < numPartitions; i++ {
-		partitions[i] = newTablePartition()
+// defaultHashFunc is xxhash rather than murmur3: it needs no allocation
+// per call and is substantially faster on the short keys typical of a
+// memtable's hot path (Get/Put/Remove/findNode).
+func defaultHashFunc(key []byte) uint64 {
+	return xxhash.Sum64(key)
+}
+
+// MemTableOption configures a hash memTable at construction time.
+type MemTableOption func(*memTable)
+
+// WithHashFunc overrides the hash used to pick a key's partition and
+// bucket. Useful for a caller that already has a hash of the key (e.g.
+// WriteBatch's partitioner, or one keying by content address) and wants
+// to skip re-hashing it.
+func WithHashFunc(h func(key []byte) uint64) MemTableOption {
+	return func(t *memTable) { t.hashFunc = h }
+}
+
+// WithNumPartitions overrides the number of shards the table is split
+// into. Defaults to defaultNumPartitions. n <= 0 is ignored.
+func WithNumPartitions(n int) MemTableOption {
+	return func(t *memTable) {
+		if n > 0 {
+			t.numPartitions = n
+		}
+	}
+}
+
+func newMemTable(opts ...MemTableOption) *memTable {
+	t := &memTable{
+		numPartitions: defaultNumPartitions,
+		hashFunc:      defaultHashFunc,
+	}
+	for _, opt := range opts {
+		opt(t)
 	}
 
-	return &memTable{
-		partitions: partitions,
-		locks:      make([]sync.RWMutex, numPartitions),
+	t.partitions = make([]*tablePartition, t.numPartitions)
+	for i := range t.partitions {
+		t.partitions[i] = newTablePartition()
 	}
+	t.locks = make([]sync.RWMutex, t.numPartitions)
+
+	return t
+}
+
+func (t *memTable) partitionOf(hash uint64) uint64 {
+	return hash % uint64(t.numPartitions)
 }
 
 func (t *memTable) Get(key []byte) (*ValuePointer, uint64) {
-	hash := hashKey(key)
+	hash := t.hashFunc(key)
 
-	p := hash >> 28
+	p := t.partitionOf(hash)
 	t.locks[p].RLock()
 	defer t.locks[p].RUnlock()
 
@@ -57,9 +183,9 @@ func (t *memTable) Get(key []byte) (*ValuePointer, uint64) {
 }
 
 func (t *memTable) Remove(key []byte, seqNumber uint64) *ValuePointer {
-	hash := hashKey(key)
+	hash := t.hashFunc(key)
 
-	p := hash >> 28
+	p := t.partitionOf(hash)
 
 	t.locks[p].Lock()
 	defer t.locks[p].Unlock()
@@ -68,9 +194,9 @@ func (t *memTable) Remove(key []byte, seqNumber uint64) *ValuePointer {
 }
 
 func (t *memTable) Put(key []byte, seqNumber uint64, ptr *ValuePointer) (*ValuePointer, bool) {
-	hash := hashKey(key)
+	hash := t.hashFunc(key)
 
-	p := hash >> 28
+	p := t.partitionOf(hash)
 
 	t.locks[p].Lock()
 	defer t.locks[p].Unlock()
@@ -78,10 +204,59 @@ func (t *memTable) Put(key []byte, seqNumber uint64, ptr *ValuePointer) (*ValueP
 	return t.partitions[p].put(key, seqNumber, ptr, hash)
 }
 
+// GetAt returns the version of key that was current as of seq.
+func (t *memTable) GetAt(key []byte, seq uint64) (*ValuePointer, bool) {
+	hash := t.hashFunc(key)
+
+	p := t.partitionOf(hash)
+	t.locks[p].RLock()
+	defer t.locks[p].RUnlock()
+
+	return t.partitions[p].getAt(key, seq, hash)
+}
+
+// SetRetention wires r as the retention list every partition consults
+// when deciding how much version history to keep on write. It is called
+// once by the owning DB after constructing the memtable.
+func (t *memTable) SetRetention(r snapshotRetention) {
+	for _, p := range t.partitions {
+		p.retention = r
+	}
+}
+
+// CompactVersions walks every partition and prunes each key's version
+// chain down to what the current set of live snapshots still needs. It
+// runs in the background whenever a snapshot's release drops the last
+// reference to its seqNumber, since that is when versions retained only
+// for it can finally be reclaimed.
+func (t *memTable) CompactVersions() {
+	for i := range t.partitions {
+		t.locks[i].Lock()
+		t.partitions[i].compactVersions()
+		t.locks[i].Unlock()
+	}
+}
+
+func (t *tablePartition) compactVersions() {
+	for b := 0; b <= 1; b++ {
+		for _, head := range t.buckets[b] {
+			var prev *node
+			for nd := head; nd != nil; {
+				next := nd.next
+				t.pruneVersions(nd)
+				if !t.reclaimIfDead(b, prev, nd) {
+					prev = nd
+				}
+				nd = next
+			}
+		}
+	}
+}
+
 func (t *memTable) ContainsKey(key []byte) bool {
-	hash := hashKey(key)
+	hash := t.hashFunc(key)
 
-	p := hash >> 28
+	p := t.partitionOf(hash)
 
 	t.locks[p].RLock()
 	defer t.locks[p].RUnlock()
@@ -97,6 +272,58 @@ func newTablePartition() *tablePartition {
 		buckets:          buckets,
 		resizeInProgress: false,
 		nextResizeIndex:  -1,
+		nodePool:         sync.Pool{New: func() interface{} { return &node{} }},
+	}
+}
+
+// allocNode gets a node from the pool instead of allocating a fresh one,
+// to dampen the allocator pressure of new-key inserts.
+func (t *tablePartition) allocNode(key []byte, hash uint64) *node {
+	nd := t.nodePool.Get().(*node)
+	nd.key = key
+	nd.hash = hash
+	nd.next = nil
+	nd.versions = nil
+	return nd
+}
+
+// releaseNode zeroes nd and returns it to the pool. The caller must have
+// already unlinked nd from its bucket.
+func (t *tablePartition) releaseNode(nd *node) {
+	nd.key = nil
+	nd.hash = 0
+	nd.next = nil
+	nd.versions = nil
+	t.nodePool.Put(nd)
+}
+
+// allocBuckets returns a zeroed bucket array of the given size, reusing a
+// previous generation's backing array of the same size if completeResize
+// stashed one, instead of calling make.
+func (t *tablePartition) allocBuckets(size int) []*node {
+	if buckets, ok := t.bucketFreeList[size]; ok {
+		delete(t.bucketFreeList, size)
+		return buckets
+	}
+	return make([]*node, size)
+}
+
+// recycleBuckets stashes buckets in the free list, keyed by its length,
+// so the next resize to that size can reuse the backing array. Only one
+// spare per size is kept - this is meant to dampen resize churn, not to
+// act as a general-purpose allocator.
+func (t *tablePartition) recycleBuckets(buckets []*node) {
+	if buckets == nil {
+		return
+	}
+	if t.bucketFreeList == nil {
+		t.bucketFreeList = make(map[int][]*node)
+	}
+	if _, exists := t.bucketFreeList[len(buckets)]; !exists {
+		for i := range buckets {
+			buckets[i] = nil
+		}
+		t.bucketFreeList[len(buckets)] = buckets
 	}
 }
 
@@ -108,13 +335,13 @@ func (t *memTable) Size() int {
 	return n
 }
 
-func (t *tablePartition) findNode(key []byte, hash uint32) (int, *node, *node) {
+func (t *tablePartition) findNode(key []byte, hash uint64) (int, *node, *node) {
 	for i := 0; i <= 1; i++ {
 		buckets := t.buckets[i]
 
 		if buckets != nil {
 			var prevNode *node = nil
-			for node := buckets[hash%uint32(len(buckets))]; node != nil; prevNode, node = node, node.next {
+			for node := buckets[hash%uint64(len(buckets))]; node != nil; prevNode, node = node, node.next {
 				if bytes.Equal(node.key, key) {
 					return i, prevNode, node
 				}
@@ -124,13 +351,9 @@ func (t *tablePartition) findNode(key []byte, hash uint32) (int, *node, *node) {
 	return -1, nil, nil
 }
 
-func hashKey(key []byte) uint32 {
-	hash := murmur3.New32()
-	hash.Write(key)
-	return hash.Sum32()
-}
-
 func (t *tablePartition) completeResize() {
+	t.recycleBuckets(t.buckets[0])
+
 	t.buckets[0] = t.buckets[1]
 	t.buckets[1] = nil
 
@@ -138,53 +361,72 @@ func (t *tablePartition) completeResize() {
 	t.nextResizeIndex = -1
 }
 
-func (t *tablePartition) get(key []byte, hash uint32) (*ValuePointer, uint64) {
+func (t *tablePartition) get(key []byte, hash uint64) (*ValuePointer, uint64) {
 	_, _, nd := t.findNode(key, hash)
 	if nd != nil {
-		return nd.ptr, nd.seqNumber
+		return nd.headPtr(), nd.headSeq()
 	}
 	return nil, 0
 }
 
+// getAt returns the version of key that was current as of seq, i.e. the
+// newest version with seqNumber <= seq.
+func (t *tablePartition) getAt(key []byte, seq uint64, hash uint64) (*ValuePointer, bool) {
+	_, _, nd := t.findNode(key, hash)
+	if nd == nil {
+		return nil, false
+	}
+
+	for v := nd.versions; v != nil; v = v.next {
+		if v.seqNumber <= seq {
+			return v.ptr, true
+		}
+	}
+	return nil, false
+}
+
 // replace with Swap(key, value, func(oldValue, newValue) bool)
-func (t *tablePartition) put(key []byte, seqNumber uint64, ptr *ValuePointer, hash uint32) (*ValuePointer, bool) {
+func (t *tablePartition) put(key []byte, seqNumber uint64, ptr *ValuePointer, hash uint64) (*ValuePointer, bool) {
 	t.resizeStep()
 
 	bucketIndex, prevNode, currNode := t.findNode(key, hash)
 
 	if currNode == nil {
-		currNode = &node{key: key}
+		currNode = t.allocNode(key, hash)
+		prevNode = nil
 
 		if t.resizeInProgress {
-			bucketHash := hash % uint32(len(t.buckets[1]))
+			bucketIndex = 1
+			bucketHash := hash % uint64(len(t.buckets[1]))
 			currNode.next = t.buckets[1][bucketHash]
 			t.buckets[1][bucketHash] = currNode
 		} else {
-			bucketHash := hash % uint32(len(t.buckets[0]))
+			bucketIndex = 0
+			bucketHash := hash % uint64(len(t.buckets[0]))
 			currNode.next = t.buckets[0][bucketHash]
 			t.buckets[0][bucketHash] = currNode
 		}
 
-		t.nElements.Inc()
 		t.nNodes.Inc()
 	}
 
-	if seqNumber >= currNode.seqNumber {
-		prev := currNode.ptr
+	if seqNumber >= currNode.headSeq() {
+		prev := currNode.headPtr()
 
-		if ptr == nil { // unlink if value is nil
-			if prevNode != nil {
-				prevNode.next = currNode.next
-			} else {
-				bucketHash := hash % uint32(len(t.buckets[bucketIndex]))
-				t.buckets[bucketIndex][bucketHash] = currNode.next
-			}
-			t.nNodes.Add(-1)
-		} else {
-			currNode.seqNumber = seqNumber
-			currNode.ptr = ptr
+		// A nil ptr is a tombstone, same as remove(): prepend a new
+		// version rather than unlinking the node and its whole chain, so
+		// a snapshot pinned to an earlier seqNumber still finds the
+		// version it's entitled to.
+		currNode.versions = &version{ptr: ptr, seqNumber: seqNumber, next: currNode.versions}
+		t.pruneVersions(currNode)
+
+		if prev == nil && ptr != nil {
+			t.nElements.Inc()
+		} else if prev != nil && ptr == nil {
+			t.nElements.Add(-1)
 		}
 
+		t.reclaimIfDead(bucketIndex, prevNode, currNode)
 		t.resizeIfNeeded()
 
 		return prev, true
@@ -194,13 +436,68 @@ func (t *tablePartition) put(key []byte, seqNumber uint64, ptr *ValuePointer, ha
 	return ptr, false
 }
 
+// pruneVersions drops the tail of nd's version chain that no live
+// snapshot can still reach: everything older than the version that
+// satisfies the oldest live snapshot seqNumber. With no live snapshots,
+// only the newest version is kept. The caller must hold the partition's
+// write lock.
+func (t *tablePartition) pruneVersions(nd *node) {
+	oldest, ok := uint64(0), false
+	if t.retention != nil {
+		oldest, ok = t.retention.Oldest()
+	}
+
+	if !ok {
+		if nd.versions != nil {
+			nd.versions.next = nil
+		}
+		return
+	}
+
+	v := nd.versions
+	for v != nil && v.seqNumber > oldest {
+		v = v.next
+	}
+	if v != nil {
+		v.next = nil
+	}
+}
+
+// reclaimIfDead unlinks nd from its bucket and returns it to the pool if
+// its version chain has collapsed to a single tombstone that no live
+// snapshot needs to see - at that point the node carries no information
+// a caller can't already get from its absence. The caller must hold the
+// partition's write lock and have just called pruneVersions on nd.
+func (t *tablePartition) reclaimIfDead(bucketIndex int, prevNode, nd *node) bool {
+	if t.retention != nil {
+		if _, ok := t.retention.Oldest(); ok {
+			return false
+		}
+	}
+	if nd.versions == nil || nd.versions.next != nil || nd.versions.ptr != nil {
+		return false
+	}
+
+	if prevNode != nil {
+		prevNode.next = nd.next
+	} else {
+		bucketHash := nd.hash % uint64(len(t.buckets[bucketIndex]))
+		t.buckets[bucketIndex][bucketHash] = nd.next
+	}
+	t.nNodes.Add(-1)
+	t.releaseNode(nd)
+	return true
+}
+
 func (t *tablePartition) resizeStep() {
 	if t.resizeInProgress {
 
 		t.nextResizeIndex++
 
 		for nd := t.buckets[0][t.nextResizeIndex]; nd != nil; {
-			newHash := hashKey(nd.key) % uint32(len(t.buckets[1]))
+			// nd.hash was stashed on insert, so migrating a bucket never
+			// needs to re-hash the key.
+			newHash := nd.hash % uint64(len(t.buckets[1]))
 
 			nextNode := nd.next
 			nd.next = t.buckets[1][newHash]
@@ -225,38 +522,218 @@ func (t *tablePartition) resizeIfNeeded() {
 	nNodes := t.nNodes.Get()
 	nBuckets := int32(len(t.buckets[0]))
 	if nNodes > 5*nBuckets {
-		t.buckets[1] = make([]*node, 2*nBuckets)
+		t.buckets[1] = t.allocBuckets(int(2 * nBuckets))
 		t.resizeInProgress = true
 	} else if int(nNodes) < int(nBuckets)/4 && nNodes > initialBucketSize {
-		t.buckets[1] = make([]*node, nBuckets/2)
+		t.buckets[1] = t.allocBuckets(int(nBuckets / 2))
 		t.resizeInProgress = true
 	}
 
 }
 
-func (t *tablePartition) containsKey(key []byte, hash uint32) bool {
+func (t *tablePartition) containsKey(key []byte, hash uint64) bool {
 	ptr, _ := t.get(key, hash)
 	return ptr != nil
 }
 
-func (t *tablePartition) remove(key []byte, seqNum uint64, hash uint32) *ValuePointer {
+func (t *tablePartition) remove(key []byte, seqNum uint64, hash uint64) *ValuePointer {
 	t.resizeStep()
 
-	_, _, nd := t.findNode(key, hash)
+	bucketIndex, prevNode, nd := t.findNode(key, hash)
 	if nd == nil {
 		return nil
 	}
 
-	if seqNum >= nd.seqNumber {
-		removePtr := nd.ptr
+	if seqNum >= nd.headSeq() {
+		removePtr := nd.headPtr()
 
-		nd.ptr = nil
+		// Prepend a tombstone version rather than clearing ptr in place,
+		// so readers pinned to an earlier seqNumber still find it.
+		nd.versions = &version{ptr: nil, seqNumber: seqNum, next: nd.versions}
+		t.pruneVersions(nd)
 
 		if removePtr != nil {
 			t.nElements.Add(-1)
 		}
+
+		t.reclaimIfDead(bucketIndex, prevNode, nd)
 		return removePtr
 	}
 
 	return nil
 }
+
+type hashEntry struct {
+	key       []byte
+	ptr       *ValuePointer
+	seqNumber uint64
+}
+
+// collect appends, for every key in bounds [lowerBound, upperBound), the
+// version that was current as of seq to out. Keys with no version visible
+// at seq (not yet written, or tombstoned) are skipped. The caller must
+// hold at least a read lock on the partition.
+func (t *tablePartition) collect(out *[]hashEntry, lowerBound, upperBound []byte, seq uint64) {
+	for i := 0; i <= 1; i++ {
+		for _, head := range t.buckets[i] {
+			for nd := head; nd != nil; nd = nd.next {
+				if lowerBound != nil && bytes.Compare(nd.key, lowerBound) < 0 {
+					continue
+				}
+				if upperBound != nil && bytes.Compare(nd.key, upperBound) >= 0 {
+					continue
+				}
+
+				v := nd.versions
+				for v != nil && v.seqNumber > seq {
+					v = v.next
+				}
+				if v == nil || v.ptr == nil {
+					continue
+				}
+				*out = append(*out, hashEntry{key: nd.key, ptr: v.ptr, seqNumber: v.seqNumber})
+			}
+		}
+	}
+}
+
+// NewIterator has no natural ordering to walk, so it takes a full
+// snapshot-and-sort pass over every partition. This is O(n log n) rather
+// than the skip list's native ordered traversal; callers that need cheap
+// ordered scans should pick the skip-list MemTable instead.
+func (t *memTable) NewIterator(lowerBound, upperBound []byte) Iterator {
+	entries := t.collectSorted(lowerBound, upperBound, math.MaxUint64)
+	return &hashIterator{entries: entries, idx: -1}
+}
+
+// collectSorted holds every partition's RLock for the whole scan, not
+// just while it is that partition's turn, so the snapshot it assembles
+// reflects a single point in time across partitions - otherwise a scan
+// could see partition A already reflecting one ApplyBatch call while
+// partition B still reflects an earlier one, even though each
+// individual batch is applied atomically.
+func (t *memTable) collectSorted(lowerBound, upperBound []byte, seq uint64) []hashEntry {
+	for i := range t.locks {
+		t.locks[i].RLock()
+		defer t.locks[i].RUnlock()
+	}
+
+	entries := make([]hashEntry, 0, t.Size())
+	for i := range t.partitions {
+		t.partitions[i].collect(&entries, lowerBound, upperBound, seq)
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return bytes.Compare(entries[i].key, entries[j].key) < 0
+	})
+	return entries
+}
+
+// NewSnapshot returns a view pinned to seq: reads and scans through it
+// only ever see the version of each key that was current as of seq, by
+// walking the version chains introduced for MVCC.
+func (t *memTable) NewSnapshot(seq uint64) Snapshot {
+	return &hashSnapshot{entries: t.collectSorted(nil, nil, seq)}
+}
+
+type hashIterator struct {
+	entries []hashEntry
+	idx     int
+}
+
+func (it *hashIterator) Next() bool {
+	it.idx++
+	return it.idx < len(it.entries)
+}
+
+func (it *hashIterator) Key() []byte          { return it.entries[it.idx].key }
+func (it *hashIterator) Value() *ValuePointer { return it.entries[it.idx].ptr }
+func (it *hashIterator) SeqNumber() uint64    { return it.entries[it.idx].seqNumber }
+func (it *hashIterator) Err() error           { return nil }
+func (it *hashIterator) Close() error         { return nil }
+
+type hashSnapshot struct {
+	entries []hashEntry
+}
+
+func (s *hashSnapshot) Get(key []byte) (*ValuePointer, bool) {
+	for _, e := range s.entries {
+		if bytes.Equal(e.key, key) {
+			return e.ptr, true
+		}
+	}
+	return nil, false
+}
+
+type batchOp struct {
+	seq   uint64
+	key   []byte
+	value []byte
+	hash  uint64
+	del   bool
+}
+
+// ApplyBatch groups the batch's operations by partition and locks every
+// distinct partition they touch, in ascending index order so concurrent
+// batches with overlapping partitions can never deadlock against each
+// other, before applying any of them. All locks are held for the whole
+// batch and released only once every operation has been applied, so a
+// concurrent Get/NewIterator/Snapshot never observes the batch half
+// applied.
+func (t *memTable) ApplyBatch(batch *Batch, valueOf func(value []byte) *ValuePointer) error {
+	var ops []batchOp
+
+	err := batch.Replay(batchHandlerFuncs{
+		put: func(seq uint64, key, value []byte) {
+			ops = append(ops, batchOp{seq: seq, key: key, value: value, hash: t.hashFunc(key)})
+		},
+		del: func(seq uint64, key []byte) {
+			ops = append(ops, batchOp{seq: seq, key: key, hash: t.hashFunc(key), del: true})
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	groups := make(map[uint64][]batchOp)
+	for _, op := range ops {
+		p := t.partitionOf(op.hash)
+		groups[p] = append(groups[p], op)
+	}
+
+	partitionIdx := make([]uint64, 0, len(groups))
+	for p := range groups {
+		partitionIdx = append(partitionIdx, p)
+	}
+	sort.Slice(partitionIdx, func(i, j int) bool { return partitionIdx[i] < partitionIdx[j] })
+
+	for _, p := range partitionIdx {
+		t.locks[p].Lock()
+		defer t.locks[p].Unlock()
+	}
+
+	for _, p := range partitionIdx {
+		for _, op := range groups[p] {
+			if op.del {
+				t.partitions[p].remove(op.key, op.seq, op.hash)
+			} else {
+				t.partitions[p].put(op.key, op.seq, valueOf(op.value), op.hash)
+			}
+		}
+	}
+	return nil
+}
+
+func (s *hashSnapshot) NewIterator(lowerBound, upperBound []byte) Iterator {
+	entries := make([]hashEntry, 0, len(s.entries))
+	for _, e := range s.entries {
+		if lowerBound != nil && bytes.Compare(e.key, lowerBound) < 0 {
+			continue
+		}
+		if upperBound != nil && bytes.Compare(e.key, upperBound) >= 0 {
+			continue
+		}
+		entries = append(entries, e)
+	}
+	return &hashIterator{entries: entries, idx: -1}
+}
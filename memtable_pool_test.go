@@ -0,0 +1,31 @@
+package eagle
+
+import "testing"
+
+// TestTablePartitionReclaimsDeadNodeToPool verifies that once a key's
+// version chain collapses to a bare tombstone with no live snapshot
+// needing it, put/remove actually unlink the node and return it to
+// nodePool, where the next new-key insert can reuse it.
+func TestTablePartitionReclaimsDeadNodeToPool(t *testing.T) {
+	mt := newMemTable(WithNumPartitions(1))
+	p := mt.partitions[0]
+	hash := mt.hashFunc([]byte("k"))
+
+	mt.Put([]byte("k"), 1, &ValuePointer{})
+
+	_, _, nd := p.findNode([]byte("k"), hash)
+	if nd == nil {
+		t.Fatal("expected a node to exist after Put")
+	}
+
+	mt.Remove([]byte("k"), 2)
+
+	if _, _, found := p.findNode([]byte("k"), hash); found != nil {
+		t.Fatal("expected the node to be unlinked once its version chain collapsed to a bare tombstone")
+	}
+
+	got := p.nodePool.Get().(*node)
+	if got != nd {
+		t.Fatal("expected the reclaimed node to be served back out of nodePool")
+	}
+}
@@ -0,0 +1,421 @@
+package eagle
+
+import (
+	"bytes"
+	"math"
+	"math/rand"
+	"sync"
+
+	"github.com/ostafen/eagle/util"
+)
+
+const (
+	skipListMaxLevel = 12
+	skipListP        = 0.25
+)
+
+// skipNode is a single version of a key. Nodes for the same key are kept
+// adjacent and ordered by descending seqNumber, so the newest write always
+// shadows older ones without destroying them - this is what lets a
+// snapshot pinned to an older seqNumber still find its version.
+type skipNode struct {
+	key       []byte
+	seqNumber uint64
+	ptr       *ValuePointer
+	next      []*skipNode
+}
+
+// lessThan reports whether n sorts before (key, seqNumber) in list order:
+// ascending by key, then descending by seqNumber for equal keys.
+func (n *skipNode) lessThan(key []byte, seqNumber uint64) bool {
+	c := bytes.Compare(n.key, key)
+	if c != 0 {
+		return c < 0
+	}
+	return n.seqNumber > seqNumber
+}
+
+// skipMemTable is an ordered MemTable backed by a probabilistic skip list.
+// Unlike the sharded hash memTable, it keeps every version of a key in a
+// single list ordered by (key asc, seqNumber desc), which makes both
+// ordered iteration and seqNumber-pinned snapshot reads cheap.
+type skipMemTable struct {
+	mu        sync.RWMutex
+	level     int
+	head      *skipNode
+	nodePool  sync.Pool
+	nElements util.AtomicInt32
+	retention snapshotRetention
+}
+
+func newSkipMemTable() *skipMemTable {
+	return &skipMemTable{
+		head: &skipNode{next: make([]*skipNode, skipListMaxLevel)},
+		nodePool: sync.Pool{
+			New: func() interface{} { return &skipNode{} },
+		},
+	}
+}
+
+func (m *skipMemTable) randomLevel() int {
+	level := 0
+	for level < skipListMaxLevel-1 && rand.Float64() < skipListP {
+		level++
+	}
+	return level
+}
+
+func (m *skipMemTable) allocNode(level int) *skipNode {
+	nd := m.nodePool.Get().(*skipNode)
+	if cap(nd.next) < level+1 {
+		nd.next = make([]*skipNode, level+1)
+	} else {
+		nd.next = nd.next[:level+1]
+		for i := range nd.next {
+			nd.next[i] = nil
+		}
+	}
+	return nd
+}
+
+// releaseNode clears nd and returns it to the pool. The caller must have
+// already unlinked nd from every level it appeared on.
+func (m *skipMemTable) releaseNode(nd *skipNode) {
+	nd.key = nil
+	nd.ptr = nil
+	for i := range nd.next {
+		nd.next[i] = nil
+	}
+	m.nodePool.Put(nd)
+}
+
+// insert links a new version node for (key, seqNumber, ptr) into the list
+// and returns it. The caller must hold the write lock.
+func (m *skipMemTable) insert(key []byte, seqNumber uint64, ptr *ValuePointer) *skipNode {
+	var update [skipListMaxLevel]*skipNode
+
+	cur := m.head
+	for i := m.level; i >= 0; i-- {
+		for cur.next[i] != nil && cur.next[i].lessThan(key, seqNumber) {
+			cur = cur.next[i]
+		}
+		update[i] = cur
+	}
+
+	level := m.randomLevel()
+	if level > m.level {
+		for i := m.level + 1; i <= level; i++ {
+			update[i] = m.head
+		}
+		m.level = level
+	}
+
+	nd := m.allocNode(level)
+	nd.key = key
+	nd.seqNumber = seqNumber
+	nd.ptr = ptr
+
+	for i := 0; i <= level; i++ {
+		nd.next[i] = update[i].next[i]
+		update[i].next[i] = nd
+	}
+	return nd
+}
+
+// unlink removes target from every level it participates in and returns
+// it to the node pool. The caller must hold the write lock.
+func (m *skipMemTable) unlink(target *skipNode) {
+	var update [skipListMaxLevel]*skipNode
+
+	cur := m.head
+	for i := m.level; i >= 0; i-- {
+		for cur.next[i] != nil && cur.next[i].lessThan(target.key, target.seqNumber) {
+			cur = cur.next[i]
+		}
+		update[i] = cur
+	}
+
+	for i := 0; i <= m.level; i++ {
+		if update[i].next[i] == target {
+			update[i].next[i] = target.next[i]
+		}
+	}
+	m.releaseNode(target)
+}
+
+// SetRetention wires r into the table so pruneVersions knows how much
+// version history a write must keep.
+func (m *skipMemTable) SetRetention(r snapshotRetention) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.retention = r
+}
+
+// pruneVersions drops every version of nd's key older than the version
+// that satisfies the oldest live snapshot seqNumber - mirroring
+// tablePartition.pruneVersions, but unlinking superseded skipNodes from
+// the list instead of just chain-dropping them, so they return to
+// nodePool. With no live snapshots, only nd itself survives. nd must be
+// the newest version of its key (i.e. just-inserted), and the caller
+// must hold the write lock.
+func (m *skipMemTable) pruneVersions(nd *skipNode) {
+	oldest, ok := uint64(0), false
+	if m.retention != nil {
+		oldest, ok = m.retention.Oldest()
+	}
+
+	keep := nd
+	if ok {
+		for keep.seqNumber > oldest && keep.next[0] != nil && bytes.Equal(keep.next[0].key, nd.key) {
+			keep = keep.next[0]
+		}
+	}
+
+	victim := keep.next[0]
+	for victim != nil && bytes.Equal(victim.key, nd.key) {
+		next := victim.next[0]
+		m.unlink(victim)
+		victim = next
+	}
+}
+
+// CompactVersions walks the whole list and prunes every key's version
+// chain down to what the current set of live snapshots still needs. It
+// runs in the background whenever a snapshot's release drops the last
+// reference to its seqNumber.
+func (m *skipMemTable) CompactVersions() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	nd := m.head.next[0]
+	for nd != nil {
+		key := nd.key
+		m.pruneVersions(nd)
+		for nd != nil && bytes.Equal(nd.key, key) {
+			nd = nd.next[0]
+		}
+	}
+}
+
+// latest returns the most recent version of key visible at seqNumber, or
+// found == false if no version at or before seqNumber exists. The caller
+// must hold at least a read lock.
+func (m *skipMemTable) latest(key []byte, seqNumber uint64) (ptr *ValuePointer, seq uint64, found bool) {
+	cur := m.head
+	for i := m.level; i >= 0; i-- {
+		for cur.next[i] != nil && cur.next[i].lessThan(key, seqNumber) {
+			cur = cur.next[i]
+		}
+	}
+
+	nd := cur.next[0]
+	for nd != nil && bytes.Equal(nd.key, key) {
+		if nd.seqNumber <= seqNumber {
+			return nd.ptr, nd.seqNumber, true
+		}
+		nd = nd.next[0]
+	}
+	return nil, 0, false
+}
+
+func (m *skipMemTable) Get(key []byte) (*ValuePointer, uint64) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	ptr, seq, found := m.latest(key, math.MaxUint64)
+	if !found {
+		return nil, 0
+	}
+	return ptr, seq
+}
+
+func (m *skipMemTable) Put(key []byte, seqNumber uint64, ptr *ValuePointer) (*ValuePointer, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	prevPtr, prevSeq, found := m.latest(key, math.MaxUint64)
+	if found && seqNumber < prevSeq {
+		return ptr, false
+	}
+
+	if !found || prevPtr == nil {
+		m.nElements.Inc()
+	}
+
+	nd := m.insert(key, seqNumber, ptr)
+	m.pruneVersions(nd)
+	return prevPtr, true
+}
+
+func (m *skipMemTable) Remove(key []byte, seqNumber uint64) *ValuePointer {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	prevPtr, prevSeq, found := m.latest(key, math.MaxUint64)
+	if !found || seqNumber < prevSeq {
+		return nil
+	}
+
+	if prevPtr != nil {
+		m.nElements.Add(-1)
+	}
+
+	nd := m.insert(key, seqNumber, nil)
+	m.pruneVersions(nd)
+	return prevPtr
+}
+
+func (m *skipMemTable) ContainsKey(key []byte) bool {
+	ptr, _ := m.Get(key)
+	return ptr != nil
+}
+
+func (m *skipMemTable) Size() int {
+	return int(m.nElements.Get())
+}
+
+// NewIterator returns the current, most-recent-version view of the table.
+func (m *skipMemTable) NewIterator(lowerBound, upperBound []byte) Iterator {
+	return m.newIterator(lowerBound, upperBound, math.MaxUint64)
+}
+
+func (m *skipMemTable) newIterator(lowerBound, upperBound []byte, seq uint64) *skipIterator {
+	return &skipIterator{mt: m, lower: lowerBound, upper: upperBound, seq: seq}
+}
+
+// NewSnapshot returns a view of the table pinned to seq: reads and scans
+// through it only ever see versions with seqNumber <= seq.
+func (m *skipMemTable) NewSnapshot(seq uint64) Snapshot {
+	return &skipSnapshot{mt: m, seq: seq}
+}
+
+// skipIterator walks the skip list at level 0, taking the table's read
+// lock for the duration of each Next() step rather than holding it across
+// calls, so a long-lived scan does not starve concurrent writers.
+type skipIterator struct {
+	mt      *skipMemTable
+	lower   []byte
+	upper   []byte
+	seq     uint64
+	cur     *skipNode
+	started bool
+}
+
+func (it *skipIterator) Next() bool {
+	it.mt.mu.RLock()
+	defer it.mt.mu.RUnlock()
+
+	if !it.started {
+		it.started = true
+		it.cur = it.mt.head.next[0]
+		for it.cur != nil && it.lower != nil && bytes.Compare(it.cur.key, it.lower) < 0 {
+			it.cur = it.cur.next[0]
+		}
+	} else {
+		it.skipKey(it.cur.key)
+	}
+
+	for it.cur != nil {
+		if it.upper != nil && bytes.Compare(it.cur.key, it.upper) >= 0 {
+			it.cur = nil
+			return false
+		}
+		if it.cur.seqNumber <= it.seq {
+			return true
+		}
+		// Versions are ordered newest first, so an older version of the
+		// same key may still be visible at it.seq.
+		it.cur = it.cur.next[0]
+	}
+	return false
+}
+
+// skipKey advances cur past every remaining version of key.
+func (it *skipIterator) skipKey(key []byte) {
+	for it.cur != nil && bytes.Equal(it.cur.key, key) {
+		it.cur = it.cur.next[0]
+	}
+}
+
+func (it *skipIterator) Key() []byte          { return it.cur.key }
+func (it *skipIterator) Value() *ValuePointer { return it.cur.ptr }
+func (it *skipIterator) SeqNumber() uint64    { return it.cur.seqNumber }
+func (it *skipIterator) Err() error           { return nil }
+func (it *skipIterator) Close() error         { return nil }
+
+type skipSnapshot struct {
+	mt  *skipMemTable
+	seq uint64
+}
+
+func (s *skipSnapshot) Get(key []byte) (*ValuePointer, bool) {
+	s.mt.mu.RLock()
+	defer s.mt.mu.RUnlock()
+
+	ptr, _, found := s.mt.latest(key, s.seq)
+	return ptr, found
+}
+
+func (s *skipSnapshot) NewIterator(lowerBound, upperBound []byte) Iterator {
+	return s.mt.newIterator(lowerBound, upperBound, s.seq)
+}
+
+// ApplyBatch applies every operation in batch under a single write lock,
+// so the whole batch becomes visible to readers atomically. Unlike the
+// hash memTable, the skip list has no partitions to group by, so there is
+// nothing to gain from splitting the lock hold.
+func (m *skipMemTable) ApplyBatch(batch *Batch, valueOf func(value []byte) *ValuePointer) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return batch.Replay(batchHandlerFuncs{
+		put: func(seq uint64, key, value []byte) {
+			prevPtr, prevSeq, found := m.latest(key, math.MaxUint64)
+			if found && seq < prevSeq {
+				return
+			}
+			if !found || prevPtr == nil {
+				m.nElements.Inc()
+			}
+			nd := m.insert(key, seq, valueOf(value))
+			m.pruneVersions(nd)
+		},
+		del: func(seq uint64, key []byte) {
+			prevPtr, prevSeq, found := m.latest(key, math.MaxUint64)
+			if !found || seq < prevSeq {
+				return
+			}
+			if prevPtr != nil {
+				m.nElements.Add(-1)
+			}
+			nd := m.insert(key, seq, nil)
+			m.pruneVersions(nd)
+		},
+	})
+}
+
+// MemTableKind selects which MemTable implementation a DB uses.
+type MemTableKind int
+
+const (
+	// MemTableKindHash is the sharded open-chaining hash table. It offers
+	// fast point access; its ordered iteration copies and sorts the whole
+	// table on every NewIterator call.
+	MemTableKindHash MemTableKind = iota
+	// MemTableKindSkipList is the ordered skip list. It supports cheap
+	// range scans and seqNumber-pinned snapshots at the cost of slightly
+	// slower point access.
+	MemTableKindSkipList
+)
+
+// newMemTableOfKind builds the MemTable implementation selected by a DB's
+// MemTableKind option. opts only apply to MemTableKindHash - the skip
+// list has no partitions or hash function to configure.
+func newMemTableOfKind(kind MemTableKind, opts ...MemTableOption) MemTable {
+	switch kind {
+	case MemTableKindSkipList:
+		return newSkipMemTable()
+	default:
+		return newMemTable(opts...)
+	}
+}